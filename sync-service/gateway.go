@@ -0,0 +1,149 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// consistency levels accepted by the query gateway.
+const (
+	consistencyStrong         = "strong"
+	consistencyReadYourWrites = "read-your-writes"
+	consistencyStale          = "stale"
+)
+
+type gatewayRequest struct {
+	SQL         string        `json:"sql"`
+	Args        []interface{} `json:"args"`
+	Consistency string        `json:"consistency"`
+}
+
+// queryHandler executes a statement against the embedded replica via
+// db.Query and returns the resulting rows as JSON. Only the SQL policy
+// gates the statement; it is not checked to be read-only.
+func (s *SyncService) queryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "only POST method allowed")
+		return
+	}
+
+	var req gatewayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	if err := s.policy.Check(req.SQL); err != nil {
+		writeJSONError(w, http.StatusForbidden, fmt.Sprintf("statement not permitted: %v", err))
+		return
+	}
+
+	if req.Consistency == consistencyStrong {
+		if err := s.scheduler.RunNow(r.Context()); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("sync before strong read failed: %v", err))
+			return
+		}
+	}
+
+	rows, err := s.db.Query(req.SQL, req.Args...)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("query failed: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	columns, result, err := rowsToJSON(rows)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to read rows: %v", err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  "ok",
+		"columns": columns,
+		"rows":    result,
+	})
+}
+
+// execHandler runs a write statement against the replica's writer path,
+// then opportunistically refreshes the local replica so subsequent reads
+// observe the write.
+func (s *SyncService) execHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "only POST method allowed")
+		return
+	}
+
+	var req gatewayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	if err := s.policy.Check(req.SQL); err != nil {
+		writeJSONError(w, http.StatusForbidden, fmt.Sprintf("statement not permitted: %v", err))
+		return
+	}
+
+	result, err := s.db.Exec(req.SQL, req.Args...)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("exec failed: %v", err))
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	lastInsertID, _ := result.LastInsertId()
+
+	// Pull-through refresh: make the write immediately visible on this
+	// replica instead of waiting for the next scheduled sync. Routed
+	// through the scheduler (rather than a bare rawSync) so it's reflected
+	// in syncs_total/last_sync_unix/etc. on /info and /metrics.
+	if err := s.scheduler.RunNow(r.Context()); err != nil {
+		log.Printf("post-write replica refresh failed: %v", err)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":         "ok",
+		"rows_affected":  rowsAffected,
+		"last_insert_id": lastInsertID,
+	})
+}
+
+// rowsToJSON drains rows into column names and a slice of JSON-safe row
+// values, converting []byte to string along the way.
+func rowsToJSON(rows *sql.Rows) ([]string, []map[string]interface{}, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var result []map[string]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		scanDest := make([]interface{}, len(columns))
+		for i := range values {
+			scanDest[i] = &values[i]
+		}
+		if err := rows.Scan(scanDest...); err != nil {
+			return nil, nil, err
+		}
+
+		row := make(map[string]interface{}, len(columns))
+		for i, col := range columns {
+			if b, ok := values[i].([]byte); ok {
+				row[col] = string(b)
+			} else {
+				row[col] = values[i]
+			}
+		}
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return columns, result, nil
+}
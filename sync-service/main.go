@@ -1,24 +1,39 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/tursodatabase/go-libsql"
+
+	"github.com/pyrex41/o_email_schedule/sync-service/policy"
+	"github.com/pyrex41/o_email_schedule/sync-service/replica"
+	syncpkg "github.com/pyrex41/o_email_schedule/sync-service/sync"
 )
 
 type SyncService struct {
-	connector *libsql.Connector
-	db        *sql.DB
-	dbPath    string
+	connector       *libsql.Connector
+	connMu          sync.Mutex // serializes every Sync()/Close() call against the connector
+	db              *sql.DB
+	dbPath          string
+	scheduler       *syncpkg.Scheduler
+	policy          *policy.Policy
+	stalenessWindow time.Duration
 }
 
-func NewSyncService(dbPath, primaryUrl, authToken string) (*SyncService, error) {
+func NewSyncService(ctx context.Context, dbPath, primaryUrl, authToken string, sqlPolicy *policy.Policy, syncInterval, stalenessWindow time.Duration) (*SyncService, error) {
 	// Create directory for the database if it doesn't exist
 	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
 		return nil, fmt.Errorf("failed to create database directory: %w", err)
@@ -27,11 +42,11 @@ func NewSyncService(dbPath, primaryUrl, authToken string) (*SyncService, error)
 	log.Printf("Creating embedded replica at: %s", dbPath)
 	log.Printf("Syncing with: %s", primaryUrl)
 
-	// Create embedded replica connector with periodic sync every 2 minutes
+	// Create embedded replica connector. Periodic syncing is now driven by
+	// the Scheduler below rather than libsql.WithSyncInterval.
 	connector, err := libsql.NewEmbeddedReplicaConnector(dbPath, primaryUrl,
 		libsql.WithAuthToken(authToken),
-		libsql.WithSyncInterval(2*time.Minute), // Auto-sync every 2 minutes
-		libsql.WithReadYourWrites(true),        // Enable read-your-writes consistency
+		libsql.WithReadYourWrites(true), // Enable read-your-writes consistency
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create connector: %w", err)
@@ -43,14 +58,24 @@ func NewSyncService(dbPath, primaryUrl, authToken string) (*SyncService, error)
 	}
 
 	service := &SyncService{
-		connector: connector,
-		db:        db,
-		dbPath:    dbPath,
+		connector:       connector,
+		db:              db,
+		dbPath:          dbPath,
+		policy:          sqlPolicy,
+		stalenessWindow: stalenessWindow,
 	}
+	service.scheduler = syncpkg.NewScheduler(syncpkg.Config{
+		BaseInterval: syncInterval,
+		MaxInterval:  30 * time.Minute,
+		SyncTimeout:  30 * time.Second,
+	}, service.syncOnce)
+	service.scheduler.Start(ctx)
 
-	// Perform initial sync
+	// Perform the initial sync through the scheduler itself, so its counters
+	// (in particular lastSuccessUnix) are populated before the first
+	// /readyz check rather than only after the first BaseInterval tick.
 	log.Println("Performing initial sync...")
-	if err := service.ManualSync(); err != nil {
+	if err := service.scheduler.RunNow(ctx); err != nil {
 		log.Printf("Initial sync failed (will retry): %v", err)
 	} else {
 		log.Println("Initial sync completed successfully")
@@ -59,15 +84,60 @@ func NewSyncService(dbPath, primaryUrl, authToken string) (*SyncService, error)
 	return service, nil
 }
 
-func (s *SyncService) ManualSync() error {
-	_, err := s.connector.Sync()
-	return err
+// syncOnce is the Scheduler's Func: a single replica sync respecting ctx.
+func (s *SyncService) syncOnce(ctx context.Context) error {
+	return s.rawSync(ctx)
+}
+
+// rawSync calls the connector's Sync, serialized against every other
+// Sync()/Close() call via connMu. libsql's Connector has no internal
+// locking, so concurrent Sync()/Sync() or Sync()/Close() calls are a data
+// race; this is the single place that calls the connector's Sync.
+//
+// Sync() itself takes no context and can block indefinitely against a
+// hung or partitioned primary, so ctx's deadline is enforced by racing the
+// call against ctx.Done() on a separate goroutine and handing off on
+// timeout, the same way replica/manager.go's syncOnceWithTimeout does for per-org
+// syncs. Without this, a single hung sync would wedge the scheduler
+// goroutine forever, and Close's scheduler.Stop() would never return.
+func (s *SyncService) rawSync(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		s.connMu.Lock()
+		defer s.connMu.Unlock()
+		_, err := s.connector.Sync()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		log.Printf("sync exceeded its deadline; letting it finish in the background")
+		go func() {
+			if err := <-done; err != nil {
+				log.Printf("background sync failed: %v", err)
+			}
+		}()
+		return ctx.Err()
+	}
+}
+
+// TriggerSync requests an on-demand sync, coalesced with any sync already
+// pending on the scheduler.
+func (s *SyncService) TriggerSync() {
+	s.scheduler.TriggerNow()
 }
 
 func (s *SyncService) Close() error {
+	if s.scheduler != nil {
+		s.scheduler.Stop()
+	}
 	if s.db != nil {
 		s.db.Close()
 	}
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
 	if s.connector != nil {
 		return s.connector.Close()
 	}
@@ -78,51 +148,162 @@ func (s *SyncService) GetDBPath() string {
 	return s.dbPath
 }
 
-// Health check endpoint
-func (s *SyncService) healthHandler(w http.ResponseWriter, r *http.Request) {
-	// Test database connection
+// writeJSON writes v as a JSON response body with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed to encode JSON response: %v", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, errMsg string) {
+	writeJSON(w, status, map[string]string{"error": errMsg})
+}
+
+// livezHandler reports whether the process itself is alive. It never
+// touches the database, so it can't be blocked by a stuck replica.
+func (s *SyncService) livezHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "ok",
+	})
+}
+
+// readyzHandler reports whether the replica is fresh enough to serve
+// traffic: at least one sync must have succeeded, and the most recent
+// successful sync must be within the configured staleness window.
+func (s *SyncService) readyzHandler(w http.ResponseWriter, r *http.Request) {
 	if err := s.db.Ping(); err != nil {
-		w.WriteHeader(http.StatusServiceUnavailable)
-		fmt.Fprintf(w, "Database unavailable: %v", err)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+			"status": "unavailable",
+			"error":  fmt.Sprintf("database unavailable: %v", err),
+		})
+		return
+	}
+
+	stats := s.scheduler.Stats()
+	if stats.LastSuccessUnix == 0 {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+			"status": "unavailable",
+			"error":  "initial sync has not completed yet",
+		})
+		return
+	}
+
+	age := time.Since(time.Unix(stats.LastSuccessUnix, 0))
+	if age > s.stalenessWindow {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+			"status": "unavailable",
+			"error":  fmt.Sprintf("last successful sync was %s ago, exceeds staleness window of %s", age, s.stalenessWindow),
+		})
 		return
 	}
 
-	// Test that we can read from the database
 	var count int
-	err := s.db.QueryRow("SELECT COUNT(*) FROM organizations").Scan(&count)
-	if err != nil {
-		w.WriteHeader(http.StatusServiceUnavailable)
-		fmt.Fprintf(w, "Database query failed: %v", err)
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM organizations").Scan(&count); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+			"status": "unavailable",
+			"error":  fmt.Sprintf("database query failed: %v", err),
+		})
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "OK - Database path: %s, Organizations count: %d", s.dbPath, count)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":    "ok",
+		"db_path":   s.dbPath,
+		"org_count": count,
+	})
 }
 
-// Manual sync endpoint
+// Manual sync endpoint. The actual sync runs on the scheduler goroutine;
+// concurrent requests are coalesced into a single sync.
 func (s *SyncService) syncHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		fmt.Fprintf(w, "Only POST method allowed")
+		writeJSONError(w, http.StatusMethodNotAllowed, "only POST method allowed")
 		return
 	}
 
 	log.Println("Manual sync requested via API")
-	if err := s.ManualSync(); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Sync failed: %v", err)
+	s.TriggerSync()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "triggered",
+	})
+}
+
+// Info endpoint to get database path and scheduler stats
+func (s *SyncService) infoHandler(w http.ResponseWriter, r *http.Request) {
+	var count int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM organizations").Scan(&count); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+			"status": "error",
+			"error":  fmt.Sprintf("failed to query organizations: %v", err),
+		})
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "Sync completed successfully")
+	stats := s.scheduler.Stats()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":                "running",
+		"db_path":               s.dbPath,
+		"org_count":             count,
+		"syncs_total":           stats.SyncsTotal,
+		"sync_failures_total":   stats.SyncFailuresTotal,
+		"last_sync_unix":        stats.LastSyncUnix,
+		"last_sync_duration_ms": stats.LastSyncDurationMs,
+		"last_error":            stats.LastError,
+	})
 }
 
-// Info endpoint to get database path
-func (s *SyncService) infoHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, `{"db_path": "%s", "status": "running"}`, s.dbPath)
+// Prometheus-format metrics endpoint
+func (s *SyncService) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprint(w, s.scheduler.MetricsText())
+}
+
+// authMiddleware requires a matching "Bearer <token>" Authorization header
+// on every request except those explicitly exempted (used for /livez and
+// /readyz when skipHealthAuth is set, since orchestrator probes don't send
+// credentials).
+func authMiddleware(token string, skipHealthAuth bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if skipHealthAuth && (r.URL.Path == "/livez" || r.URL.Path == "/readyz") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		want := "Bearer " + token
+		got := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// accessLogMiddleware logs method, path, status, and duration for every
+// request it wraps, including ones authMiddleware rejects.
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		log.Printf("%s %s %d %s", r.Method, r.URL.Path, sw.status, time.Since(start))
+	})
+}
+
+// statusWriter captures the status code written by a handler so it can be
+// included in the access log.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
 }
 
 func main() {
@@ -131,6 +312,11 @@ func main() {
 	authToken := os.Getenv("CENTRAL_DB_TOKEN")
 	dbPath := os.Getenv("REPLICA_DB_PATH")
 	port := os.Getenv("SYNC_SERVICE_PORT")
+	serviceToken := os.Getenv("SYNC_SERVICE_TOKEN")
+	skipHealthAuth := os.Getenv("SYNC_SERVICE_SKIP_HEALTH_AUTH") == "true"
+	policyPath := os.Getenv("SYNC_SERVICE_POLICY_PATH")
+	orgsBaseDir := os.Getenv("ORGS_REPLICA_BASE_DIR")
+	orgsConfigPath := os.Getenv("ORGS_CONFIG_PATH")
 
 	// Set defaults
 	if dbPath == "" {
@@ -139,47 +325,147 @@ func main() {
 	if port == "" {
 		port = "9191"
 	}
+	if orgsBaseDir == "" {
+		orgsBaseDir = "./data/orgs"
+	}
+
+	syncInterval := 2 * time.Minute
+	if v := os.Getenv("SYNC_INTERVAL_SECONDS"); v != "" {
+		secs, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("invalid SYNC_INTERVAL_SECONDS %q: %v", v, err)
+		}
+		syncInterval = time.Duration(secs) * time.Second
+	}
+
+	stalenessMultiplier := 3.0
+	if v := os.Getenv("SYNC_STALENESS_MULTIPLIER"); v != "" {
+		m, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Fatalf("invalid SYNC_STALENESS_MULTIPLIER %q: %v", v, err)
+		}
+		stalenessMultiplier = m
+	}
+	stalenessWindow := time.Duration(float64(syncInterval) * stalenessMultiplier)
 
 	if primaryUrl == "" || authToken == "" {
 		log.Fatal("CENTRAL_DB_URL and CENTRAL_DB_TOKEN environment variables are required")
 	}
+	if serviceToken == "" {
+		log.Fatal("SYNC_SERVICE_TOKEN environment variable is required")
+	}
+
+	var sqlPolicy *policy.Policy
+	if policyPath != "" {
+		p, err := policy.Load(policyPath)
+		if err != nil {
+			log.Fatalf("Failed to load SQL policy from %s: %v", policyPath, err)
+		}
+		sqlPolicy = p
+		log.Printf("Loaded SQL policy from %s", policyPath)
+	} else {
+		sqlPolicy = policy.DenyAll()
+		log.Printf("No SYNC_SERVICE_POLICY_PATH set; /query and /exec will reject all statements")
+	}
 
 	log.Printf("Starting Turso sync service...")
 	log.Printf("Primary URL: %s", primaryUrl)
 	log.Printf("Replica path: %s", dbPath)
 	log.Printf("Port: %s", port)
 
-	// Create sync service
-	service, err := NewSyncService(dbPath, primaryUrl, authToken)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Create sync service. NewSyncService starts the scheduler and drives the
+	// initial sync through it, so readiness reflects startup immediately.
+	service, err := NewSyncService(ctx, dbPath, primaryUrl, authToken, sqlPolicy, syncInterval, stalenessWindow)
 	if err != nil {
 		log.Fatalf("Failed to create sync service: %v", err)
 	}
-	defer service.Close()
+
+	var orgsStaticConfig map[string]replica.OrgConfig
+	if orgsConfigPath != "" {
+		cfg, err := replica.LoadStaticConfig(orgsConfigPath)
+		if err != nil {
+			log.Fatalf("Failed to load org config from %s: %v", orgsConfigPath, err)
+		}
+		orgsStaticConfig = cfg
+		log.Printf("Loaded %d org(s) from %s", len(cfg), orgsConfigPath)
+	}
+
+	replicaManager := replica.NewManager(replica.Config{
+		BaseDir:      orgsBaseDir,
+		SyncInterval: syncInterval,
+		SyncTimeout:  30 * time.Second,
+	}, resolveOrg(service.db, orgsStaticConfig))
+	replicaManager.Start(ctx)
 
 	// Setup HTTP handlers
-	http.HandleFunc("/health", service.healthHandler)
-	http.HandleFunc("/sync", service.syncHandler)
-	http.HandleFunc("/info", service.infoHandler)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", service.livezHandler)
+	mux.HandleFunc("/readyz", service.readyzHandler)
+	mux.HandleFunc("/sync", service.syncHandler)
+	mux.HandleFunc("/info", service.infoHandler)
+	mux.HandleFunc("/metrics", service.metricsHandler)
+	mux.HandleFunc("/query", service.queryHandler)
+	mux.HandleFunc("/exec", service.execHandler)
+	mux.Handle("/orgs/", &orgsRouter{manager: replicaManager})
 
 	// Root handler with basic info
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintf(w, `Turso Sync Service
-		
-Available endpoints:
-- GET  /health - Health check
-- POST /sync   - Manual sync
-- GET  /info   - Service info
-		
-Database replica path: %s
-Auto-sync interval: 2 minutes
-`, service.GetDBPath())
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":  "running",
+			"db_path": service.GetDBPath(),
+			"endpoints": map[string]string{
+				"GET /livez":            "liveness probe",
+				"GET /readyz":           "readiness probe",
+				"POST /sync":            "manual sync",
+				"GET /info":             "service info",
+				"GET /metrics":          "prometheus metrics",
+				"POST /query":           "SQL query against the replica, returning rows",
+				"POST /exec":            "SQL write against the replica",
+				"POST /orgs/{id}/sync":  "trigger a sync for one organization's replica",
+				"GET /orgs/{id}/health": "health check for one organization's replica",
+				"GET /orgs/{id}/info":   "info for one organization's replica",
+			},
+		})
 	})
 
-	log.Printf("Sync service running on port %s", port)
-	log.Printf("Database replica available at: %s", service.GetDBPath())
-	log.Printf("Health check: http://localhost:%s/health", port)
+	handler := accessLogMiddleware(authMiddleware(serviceToken, skipHealthAuth, mux))
 
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatalf("HTTP server failed: %v", err)
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: handler,
 	}
+
+	go func() {
+		log.Printf("Sync service running on port %s", port)
+		log.Printf("Database replica available at: %s", service.GetDBPath())
+		log.Printf("Liveness check: http://localhost:%s/livez", port)
+		log.Printf("Readiness check: http://localhost:%s/readyz", port)
+
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("HTTP server failed: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutdown signal received, draining in-flight requests...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server shutdown error: %v", err)
+	}
+
+	// service.Close stops the scheduler goroutine (already unblocked by ctx
+	// being done) and then closes the connector.
+	if err := service.Close(); err != nil {
+		log.Printf("error closing sync service: %v", err)
+	}
+	if err := replicaManager.Close(); err != nil {
+		log.Printf("error closing replica manager: %v", err)
+	}
+
+	log.Println("Shutdown complete")
 }
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pyrex41/o_email_schedule/sync-service/replica"
+)
+
+// resolveOrg builds a replica.ResolveFunc that checks the static config
+// first and falls back to the central replica's organizations table.
+func resolveOrg(centralDB *sql.DB, staticConfig map[string]replica.OrgConfig) replica.ResolveFunc {
+	return func(ctx context.Context, orgID string) (string, string, error) {
+		if cfg, ok := staticConfig[orgID]; ok {
+			return cfg.DBURL, cfg.AuthToken, nil
+		}
+
+		var dbURL, authToken string
+		err := centralDB.QueryRowContext(ctx,
+			"SELECT db_url, auth_token FROM organizations WHERE id = ?", orgID,
+		).Scan(&dbURL, &authToken)
+		if err != nil {
+			return "", "", fmt.Errorf("no config entry and DB lookup failed: %w", err)
+		}
+		return dbURL, authToken, nil
+	}
+}
+
+// orgsRouter dispatches /orgs/{id}/{action} requests to the ReplicaManager,
+// since the stdlib mux in this Go version has no path-parameter support.
+type orgsRouter struct {
+	manager *replica.Manager
+}
+
+func (o *orgsRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	orgID, action, ok := parseOrgPath(r.URL.Path)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "expected /orgs/{id}/{sync,health,info}")
+		return
+	}
+
+	switch action {
+	case "sync":
+		o.syncHandler(w, r, orgID)
+	case "health":
+		o.healthHandler(w, r, orgID)
+	case "info":
+		o.infoHandler(w, r, orgID)
+	default:
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("unknown org action %q", action))
+	}
+}
+
+// parseOrgPath splits "/orgs/{id}/{action}" into its components.
+func parseOrgPath(path string) (orgID, action string, ok bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "orgs" || parts[1] == "" || parts[2] == "" {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+func (o *orgsRouter) syncHandler(w http.ResponseWriter, r *http.Request, orgID string) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "only POST method allowed")
+		return
+	}
+
+	if _, err := o.manager.Get(r.Context(), orgID); err != nil {
+		writeJSONError(w, http.StatusBadGateway, fmt.Sprintf("failed to open replica for org %q: %v", orgID, err))
+		return
+	}
+
+	if err := o.manager.TriggerSync(orgID); err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "triggered",
+		"org_id": orgID,
+	})
+}
+
+func (o *orgsRouter) healthHandler(w http.ResponseWriter, r *http.Request, orgID string) {
+	stats, err := o.manager.StatsFor(r.Context(), orgID)
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, fmt.Sprintf("failed to open replica for org %q: %v", orgID, err))
+		return
+	}
+
+	if !stats.InitialSyncDone {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+			"status": "unavailable",
+			"org_id": orgID,
+			"error":  "initial sync has not completed yet",
+		})
+		return
+	}
+
+	age := time.Since(time.Unix(stats.LastSyncUnix, 0))
+	if staleness := o.manager.StalenessWindow(); age > staleness {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+			"status": "unavailable",
+			"org_id": orgID,
+			"error":  fmt.Sprintf("last successful sync was %s ago, exceeds staleness window of %s", age, staleness),
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "ok",
+		"org_id": orgID,
+	})
+}
+
+func (o *orgsRouter) infoHandler(w http.ResponseWriter, r *http.Request, orgID string) {
+	stats, err := o.manager.StatsFor(r.Context(), orgID)
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, fmt.Sprintf("failed to open replica for org %q: %v", orgID, err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"org_id":            orgID,
+		"db_path":           stats.DBPath,
+		"initial_sync_done": stats.InitialSyncDone,
+		"last_sync_unix":    stats.LastSyncUnix,
+		"last_error":        stats.LastError,
+	})
+}
@@ -0,0 +1,82 @@
+// Package policy implements a regex-based allow/deny list for SQL statements
+// accepted by the query gateway, so the sync service can be exposed to
+// internal callers without becoming an open SQL endpoint.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// config is the on-disk JSON shape: lists of regular expressions matched
+// against the incoming SQL statement.
+type config struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// Policy decides whether a SQL statement may be executed through the query
+// gateway. Deny patterns are checked first and always win; a statement must
+// then match at least one allow pattern.
+type Policy struct {
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+// Load reads a policy config file from path. A policy loaded from an empty
+// or missing allowlist denies every statement, so callers must opt in
+// explicitly.
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file %q: %w", path, err)
+	}
+
+	var cfg config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %q: %w", path, err)
+	}
+
+	p := &Policy{}
+	for _, pattern := range cfg.Allow {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allow pattern %q: %w", pattern, err)
+		}
+		p.allow = append(p.allow, re)
+	}
+	for _, pattern := range cfg.Deny {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deny pattern %q: %w", pattern, err)
+		}
+		p.deny = append(p.deny, re)
+	}
+
+	return p, nil
+}
+
+// DenyAll returns a Policy that rejects every statement, used when no policy
+// file is configured so the gateway fails closed rather than open.
+func DenyAll() *Policy {
+	return &Policy{}
+}
+
+// Check returns an error if sql is not permitted by the policy.
+func (p *Policy) Check(sql string) error {
+	for _, re := range p.deny {
+		if re.MatchString(sql) {
+			return fmt.Errorf("statement matches deny rule %q", re.String())
+		}
+	}
+
+	for _, re := range p.allow {
+		if re.MatchString(sql) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("statement does not match any allow rule")
+}
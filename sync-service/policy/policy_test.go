@@ -0,0 +1,58 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writePolicyFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+	return path
+}
+
+func TestCheckDenyWinsOverAllow(t *testing.T) {
+	path := writePolicyFile(t, `{
+		"allow": ["^SELECT"],
+		"deny": ["DROP TABLE"]
+	}`)
+
+	p, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if err := p.Check("SELECT * FROM organizations"); err != nil {
+		t.Errorf("expected allowed statement to pass, got: %v", err)
+	}
+	if err := p.Check("SELECT 1; DROP TABLE organizations"); err == nil {
+		t.Error("expected statement matching a deny rule to be rejected even though it also matches an allow rule")
+	}
+}
+
+func TestCheckMustMatchSomeAllowRule(t *testing.T) {
+	path := writePolicyFile(t, `{
+		"allow": ["^SELECT"],
+		"deny": []
+	}`)
+
+	p, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if err := p.Check("INSERT INTO organizations VALUES (1)"); err == nil {
+		t.Error("expected statement matching no allow rule to be rejected")
+	}
+}
+
+func TestDenyAllRejectsEverything(t *testing.T) {
+	p := DenyAll()
+	if err := p.Check("SELECT 1"); err == nil {
+		t.Error("expected DenyAll policy to reject every statement")
+	}
+}
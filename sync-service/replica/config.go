@@ -0,0 +1,31 @@
+package replica
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// OrgConfig is one organization's remote database location, as loaded from
+// a static config file.
+type OrgConfig struct {
+	DBURL     string `json:"db_url"`
+	AuthToken string `json:"auth_token"`
+}
+
+// LoadStaticConfig reads a JSON file mapping org id -> OrgConfig. It is
+// intended as an override in front of the central DB's organizations
+// table: callers should consult this map first and fall back to a DB
+// lookup on miss.
+func LoadStaticConfig(path string) (map[string]OrgConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read org config file %q: %w", path, err)
+	}
+
+	var cfg map[string]OrgConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse org config file %q: %w", path, err)
+	}
+	return cfg, nil
+}
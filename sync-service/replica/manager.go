@@ -0,0 +1,471 @@
+// Package replica manages one embedded libsql replica per organization,
+// lazily opened and evicted on an LRU basis, with a shared worker pool
+// driving periodic syncs so the number of background goroutines stays
+// bounded regardless of how many organizations are active.
+package replica
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tursodatabase/go-libsql"
+)
+
+// ResolveFunc looks up the remote database URL and auth token for an
+// organization. It is called at most once per org per cache miss.
+type ResolveFunc func(ctx context.Context, orgID string) (dbURL, authToken string, err error)
+
+// Config controls the manager's caching and sync behavior.
+type Config struct {
+	// BaseDir is where per-org replica files are created, one subdirectory
+	// per org id.
+	BaseDir string
+	// MaxOpenReplicas caps how many replicas are kept open at once; the
+	// least-recently-used replica is evicted when the cap is exceeded.
+	MaxOpenReplicas int
+	// IdleTimeout closes replicas that haven't been used in this long,
+	// independent of MaxOpenReplicas.
+	IdleTimeout time.Duration
+	// SyncInterval is how often each open replica is synced.
+	SyncInterval time.Duration
+	// SyncTimeout bounds each individual sync attempt.
+	SyncTimeout time.Duration
+	// WorkerCount is the size of the shared sync worker pool.
+	WorkerCount int
+	// StalenessWindow is how old an org's last successful sync may be
+	// before its /orgs/{id}/health is considered unhealthy. Defaults to
+	// 3x SyncInterval.
+	StalenessWindow time.Duration
+}
+
+func (c *Config) setDefaults() {
+	if c.MaxOpenReplicas <= 0 {
+		c.MaxOpenReplicas = 50
+	}
+	if c.IdleTimeout <= 0 {
+		c.IdleTimeout = 15 * time.Minute
+	}
+	if c.SyncInterval <= 0 {
+		c.SyncInterval = 2 * time.Minute
+	}
+	if c.SyncTimeout <= 0 {
+		c.SyncTimeout = 30 * time.Second
+	}
+	if c.WorkerCount <= 0 {
+		c.WorkerCount = 4
+	}
+	if c.StalenessWindow <= 0 {
+		c.StalenessWindow = 3 * c.SyncInterval
+	}
+}
+
+// replicaEntry holds one organization's open replica and its sync state.
+type replicaEntry struct {
+	orgID     string
+	connector *libsql.Connector
+	db        *sql.DB
+	dbPath    string
+	lruElem   *list.Element
+
+	// connMu serializes every call into connector (Sync and Close) so a
+	// sync in flight can never race with eviction closing the connector
+	// out from under it.
+	connMu sync.Mutex
+
+	mu              sync.Mutex
+	lastUsed        time.Time
+	lastSyncUnix    int64
+	lastSyncError   string
+	initialSyncDone bool
+}
+
+// Manager lazily opens and caches one replica per organization.
+type Manager struct {
+	cfg     Config
+	resolve ResolveFunc
+
+	mu      sync.Mutex
+	entries map[string]*replicaEntry
+	lru     *list.List // front = most recently used
+	jobs    chan string
+	stop    chan struct{}
+	done    chan struct{}
+	started bool
+}
+
+// NewManager creates a Manager. Call Start to launch its background workers.
+func NewManager(cfg Config, resolve ResolveFunc) *Manager {
+	cfg.setDefaults()
+	return &Manager{
+		cfg:     cfg,
+		resolve: resolve,
+		entries: make(map[string]*replicaEntry),
+		lru:     list.New(),
+		jobs:    make(chan string, cfg.WorkerCount*4),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Start launches the shared sync worker pool plus the ticker goroutines
+// that enqueue periodic syncs and evict idle replicas.
+func (m *Manager) Start(ctx context.Context) {
+	m.mu.Lock()
+	if m.started {
+		m.mu.Unlock()
+		return
+	}
+	m.started = true
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < m.cfg.WorkerCount; i++ {
+		wg.Add(1)
+		go m.syncWorker(ctx, &wg)
+	}
+
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(m.cfg.SyncInterval)
+		evictTicker := time.NewTicker(m.cfg.IdleTimeout / 2)
+		defer ticker.Stop()
+		defer evictTicker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				close(m.jobs)
+				wg.Wait()
+				return
+			case <-m.stop:
+				close(m.jobs)
+				wg.Wait()
+				return
+			case <-ticker.C:
+				m.enqueueAll()
+			case <-evictTicker.C:
+				m.evictIdle()
+			}
+		}
+	}()
+}
+
+// Stop halts the background workers and waits for them to exit.
+func (m *Manager) Stop() {
+	close(m.stop)
+	<-m.done
+}
+
+// Close stops the manager and closes every open replica. Stop waits for
+// the worker pool to drain, so any sync in flight has already finished
+// (or hit its timeout and been handed off) by the time we get here.
+func (m *Manager) Close() error {
+	m.Stop()
+
+	m.mu.Lock()
+	entries := m.entries
+	m.entries = make(map[string]*replicaEntry)
+	m.lru = list.New()
+	m.mu.Unlock()
+
+	var firstErr error
+	for orgID, e := range entries {
+		if err := closeEntry(e); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("org %s: %w", orgID, err)
+		}
+	}
+	return firstErr
+}
+
+func closeEntry(e *replicaEntry) error {
+	e.connMu.Lock()
+	defer e.connMu.Unlock()
+
+	if e.db != nil {
+		e.db.Close()
+	}
+	if e.connector != nil {
+		return e.connector.Close()
+	}
+	return nil
+}
+
+// Get returns the cached replica for orgID, opening it on first use.
+func (m *Manager) Get(ctx context.Context, orgID string) (*replicaEntry, error) {
+	m.mu.Lock()
+	if e, ok := m.entries[orgID]; ok {
+		e.mu.Lock()
+		e.lastUsed = time.Now()
+		e.mu.Unlock()
+		m.lru.MoveToFront(e.lruElem)
+		m.mu.Unlock()
+		return e, nil
+	}
+	m.mu.Unlock()
+
+	dbURL, authToken, err := m.resolve(ctx, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve organization %q: %w", orgID, err)
+	}
+
+	dbPath := filepath.Join(m.cfg.BaseDir, orgID, "replica.db")
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create replica directory for org %q: %w", orgID, err)
+	}
+
+	connector, err := libsql.NewEmbeddedReplicaConnector(dbPath, dbURL,
+		libsql.WithAuthToken(authToken),
+		libsql.WithReadYourWrites(true),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connector for org %q: %w", orgID, err)
+	}
+
+	db := sql.OpenDB(connector)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		connector.Close()
+		return nil, fmt.Errorf("failed to ping replica for org %q: %w", orgID, err)
+	}
+
+	e := &replicaEntry{
+		orgID:     orgID,
+		connector: connector,
+		db:        db,
+		dbPath:    dbPath,
+		lastUsed:  time.Now(),
+	}
+
+	m.syncOnceWithTimeout(ctx, e, orgID)
+
+	return m.insertIfAbsent(orgID, e), nil
+}
+
+// insertIfAbsent registers e as orgID's open replica, unless another
+// goroutine already did so while e was being resolved and synced (two
+// concurrent Get() calls for the same cold org both reach here), in which
+// case e is discarded and the already-registered entry is returned
+// instead.
+func (m *Manager) insertIfAbsent(orgID string, e *replicaEntry) *replicaEntry {
+	m.mu.Lock()
+	if existing, ok := m.entries[orgID]; ok {
+		m.mu.Unlock()
+		closeEntry(e)
+		return existing
+	}
+
+	e.lruElem = m.lru.PushFront(orgID)
+	m.entries[orgID] = e
+	evicted := m.evictOverCapacityLocked()
+	m.mu.Unlock()
+	closeEvicted(evicted)
+
+	return e
+}
+
+// evictOverCapacityLocked removes least-recently-used replicas from the
+// cache until it is at or under MaxOpenReplicas, returning them so the
+// caller can close their connectors after releasing m.mu (closeEntry can
+// block on a sync in flight, and must never do so while m.mu is held).
+// Caller must hold m.mu.
+func (m *Manager) evictOverCapacityLocked() []*replicaEntry {
+	var evicted []*replicaEntry
+	for len(m.entries) > m.cfg.MaxOpenReplicas {
+		back := m.lru.Back()
+		if back == nil {
+			return evicted
+		}
+		orgID := back.Value.(string)
+		m.lru.Remove(back)
+		e := m.entries[orgID]
+		delete(m.entries, orgID)
+		if e != nil {
+			evicted = append(evicted, e)
+		}
+	}
+	return evicted
+}
+
+// evictIdle closes replicas that haven't been used within IdleTimeout.
+func (m *Manager) evictIdle() {
+	cutoff := time.Now().Add(-m.cfg.IdleTimeout)
+
+	m.mu.Lock()
+	var evicted []*replicaEntry
+	for orgID, e := range m.entries {
+		e.mu.Lock()
+		idle := e.lastUsed.Before(cutoff)
+		e.mu.Unlock()
+		if !idle {
+			continue
+		}
+
+		m.lru.Remove(e.lruElem)
+		delete(m.entries, orgID)
+		evicted = append(evicted, e)
+	}
+	m.mu.Unlock()
+
+	closeEvicted(evicted)
+}
+
+// closeEvicted closes each entry's connector outside of m.mu, since
+// closeEntry can block on connMu while a sync is in flight.
+func closeEvicted(entries []*replicaEntry) {
+	for _, e := range entries {
+		if err := closeEntry(e); err != nil {
+			log.Printf("error closing evicted replica for org %q: %v", e.orgID, err)
+		} else {
+			log.Printf("evicted replica for org %q", e.orgID)
+		}
+	}
+}
+
+// enqueueAll submits a sync job for every currently open replica. Jobs are
+// dropped rather than blocking if the worker pool is saturated; the next
+// tick picks them up instead.
+func (m *Manager) enqueueAll() {
+	m.mu.Lock()
+	orgIDs := make([]string, 0, len(m.entries))
+	for orgID := range m.entries {
+		orgIDs = append(orgIDs, orgID)
+	}
+	m.mu.Unlock()
+
+	for _, orgID := range orgIDs {
+		select {
+		case m.jobs <- orgID:
+		default:
+			log.Printf("sync worker pool saturated, skipping this round for org %q", orgID)
+		}
+	}
+}
+
+// TriggerSync enqueues an immediate sync for orgID if it is currently open.
+func (m *Manager) TriggerSync(orgID string) error {
+	m.mu.Lock()
+	_, ok := m.entries[orgID]
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("organization %q has no open replica", orgID)
+	}
+
+	select {
+	case m.jobs <- orgID:
+	default:
+		return fmt.Errorf("sync worker pool saturated, try again shortly")
+	}
+	return nil
+}
+
+func (m *Manager) syncWorker(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for orgID := range m.jobs {
+		m.syncOrg(ctx, orgID)
+	}
+}
+
+// syncOrg runs one sync for orgID, bounded by SyncTimeout.
+func (m *Manager) syncOrg(ctx context.Context, orgID string) {
+	m.mu.Lock()
+	e, ok := m.entries[orgID]
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	m.syncOnceWithTimeout(ctx, e, orgID)
+}
+
+// syncOnceWithTimeout runs a single sync attempt for e, bounded by the
+// manager's SyncTimeout, and records the result. The connector's Sync call
+// itself takes no context (matching the rest of this codebase), so a
+// timeout is enforced by racing it against a timer on a separate goroutine
+// rather than blocking the caller indefinitely; connMu still serializes
+// that goroutine against eviction closing the connector. Used both for an
+// org's periodic syncs and its first-access sync in Get, so a cold or
+// hung org can't wedge the calling HTTP goroutine past SyncTimeout.
+func (m *Manager) syncOnceWithTimeout(ctx context.Context, e *replicaEntry, orgID string) {
+	done := make(chan error, 1)
+	go func() {
+		e.connMu.Lock()
+		defer e.connMu.Unlock()
+		_, err := e.connector.Sync()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		e.recordSyncResult(orgID, err)
+	case <-time.After(m.cfg.SyncTimeout):
+		log.Printf("sync for org %q exceeded timeout of %s; letting it finish in the background", orgID, m.cfg.SyncTimeout)
+		go func() {
+			e.recordSyncResult(orgID, <-done)
+		}()
+	case <-ctx.Done():
+		go func() {
+			e.recordSyncResult(orgID, <-done)
+		}()
+	}
+}
+
+func (e *replicaEntry) recordSyncResult(orgID string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		e.lastSyncError = err.Error()
+		log.Printf("sync failed for org %q: %v", orgID, err)
+		return
+	}
+	e.initialSyncDone = true
+	e.lastSyncUnix = time.Now().Unix()
+	e.lastSyncError = ""
+}
+
+// Stats is a point-in-time snapshot of one organization's replica state.
+type Stats struct {
+	DBPath          string
+	InitialSyncDone bool
+	LastSyncUnix    int64
+	LastError       string
+}
+
+// StatsFor returns the current sync state for orgID's replica, opening it if
+// necessary.
+func (m *Manager) StatsFor(ctx context.Context, orgID string) (Stats, error) {
+	e, err := m.Get(ctx, orgID)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return Stats{
+		DBPath:          e.dbPath,
+		InitialSyncDone: e.initialSyncDone,
+		LastSyncUnix:    e.lastSyncUnix,
+		LastError:       e.lastSyncError,
+	}, nil
+}
+
+// StalenessWindow returns the configured staleness window used to judge
+// per-org health.
+func (m *Manager) StalenessWindow() time.Duration {
+	return m.cfg.StalenessWindow
+}
+
+// DB returns the *sql.DB for orgID's replica, opening it if necessary.
+func (m *Manager) DB(ctx context.Context, orgID string) (*sql.DB, error) {
+	e, err := m.Get(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return e.db, nil
+}
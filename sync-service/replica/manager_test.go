@@ -0,0 +1,108 @@
+package replica
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEvictOverCapacityLockedRemovesLeastRecentlyUsed(t *testing.T) {
+	m := NewManager(Config{MaxOpenReplicas: 2}, nil)
+
+	for _, id := range []string{"a", "b", "c"} {
+		e := &replicaEntry{orgID: id}
+		m.mu.Lock()
+		e.lruElem = m.lru.PushFront(id)
+		m.entries[id] = e
+		m.mu.Unlock()
+	}
+	// Pushed in order a, b, c, so the LRU order front-to-back is c, b, a:
+	// "a" is least recently used and should be the one evicted.
+
+	m.mu.Lock()
+	evicted := m.evictOverCapacityLocked()
+	m.mu.Unlock()
+
+	if len(evicted) != 1 || evicted[0].orgID != "a" {
+		t.Fatalf("evictOverCapacityLocked evicted %v, want [a]", evicted)
+	}
+
+	m.mu.Lock()
+	_, aLeft := m.entries["a"]
+	_, bLeft := m.entries["b"]
+	_, cLeft := m.entries["c"]
+	m.mu.Unlock()
+
+	if aLeft {
+		t.Error("evicted org \"a\" should no longer be in entries")
+	}
+	if !bLeft || !cLeft {
+		t.Error("non-evicted orgs \"b\" and \"c\" should remain in entries")
+	}
+}
+
+func TestEvictIdleRemovesOnlyStaleEntries(t *testing.T) {
+	m := NewManager(Config{IdleTimeout: time.Minute}, nil)
+
+	fresh := &replicaEntry{orgID: "fresh", lastUsed: time.Now()}
+	stale := &replicaEntry{orgID: "stale", lastUsed: time.Now().Add(-2 * time.Minute)}
+
+	m.mu.Lock()
+	for _, e := range []*replicaEntry{fresh, stale} {
+		e.lruElem = m.lru.PushFront(e.orgID)
+		m.entries[e.orgID] = e
+	}
+	m.mu.Unlock()
+
+	m.evictIdle()
+
+	m.mu.Lock()
+	_, freshLeft := m.entries["fresh"]
+	_, staleLeft := m.entries["stale"]
+	m.mu.Unlock()
+
+	if !freshLeft {
+		t.Error("fresh entry should not have been evicted")
+	}
+	if staleLeft {
+		t.Error("stale entry should have been evicted")
+	}
+}
+
+// TestInsertIfAbsentConcurrent exercises the race two goroutines hit when
+// they both resolve and sync the same cold org in Get(): exactly one of
+// their entries should win and be registered, and every caller should see
+// the same winner rather than each keeping its own.
+func TestInsertIfAbsentConcurrent(t *testing.T) {
+	m := NewManager(Config{MaxOpenReplicas: 50}, nil)
+	const orgID = "acme"
+	const n = 20
+
+	results := make(chan *replicaEntry, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- m.insertIfAbsent(orgID, &replicaEntry{orgID: orgID})
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	winner := <-results
+	for got := range results {
+		if got != winner {
+			t.Fatalf("insertIfAbsent returned different winners across concurrent callers")
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if got := len(m.entries); got != 1 {
+		t.Fatalf("expected exactly one registered entry for %q, got %d", orgID, got)
+	}
+	if m.entries[orgID] != winner {
+		t.Fatalf("registered entry does not match the winner every caller observed")
+	}
+}
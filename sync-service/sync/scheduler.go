@@ -0,0 +1,246 @@
+// Package sync provides a background scheduler that periodically drives an
+// embedded-replica sync, with exponential backoff on failure and counters
+// suitable for health/metrics endpoints.
+package sync
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Func performs a single sync attempt against the replica. It should respect
+// ctx's deadline.
+type Func func(ctx context.Context) error
+
+// Config controls the scheduler's timing behavior.
+type Config struct {
+	// BaseInterval is how often syncs run when they are succeeding.
+	BaseInterval time.Duration
+	// MaxInterval caps the backoff applied after repeated failures.
+	MaxInterval time.Duration
+	// SyncTimeout bounds each individual sync attempt.
+	SyncTimeout time.Duration
+}
+
+// Stats is a point-in-time snapshot of scheduler counters.
+type Stats struct {
+	SyncsTotal         uint64
+	SyncFailuresTotal  uint64
+	LastSyncUnix       int64
+	LastSuccessUnix    int64
+	LastSyncDurationMs int64
+	LastError          string
+}
+
+// Scheduler runs Func on a timer, backing off with jitter on failure and
+// resetting to BaseInterval on success. Callers can also request an
+// immediate, coalesced sync via TriggerNow.
+type Scheduler struct {
+	cfg      Config
+	syncFunc Func
+
+	trigger chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+
+	syncsTotal        uint64
+	syncFailuresTotal uint64
+	lastSyncUnix      int64
+	lastSuccessUnix   int64
+	lastSyncDuration  int64 // milliseconds
+
+	mu        sync.Mutex
+	lastError string
+}
+
+// NewScheduler creates a Scheduler. Call Start to begin running it.
+func NewScheduler(cfg Config, syncFunc Func) *Scheduler {
+	if cfg.BaseInterval <= 0 {
+		cfg.BaseInterval = 2 * time.Minute
+	}
+	if cfg.MaxInterval <= 0 {
+		cfg.MaxInterval = 30 * time.Minute
+	}
+	if cfg.SyncTimeout <= 0 {
+		cfg.SyncTimeout = 30 * time.Second
+	}
+
+	return &Scheduler{
+		cfg:      cfg,
+		syncFunc: syncFunc,
+		trigger:  make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start launches the scheduler's background goroutine. It returns
+// immediately; the goroutine runs until Stop is called or ctx is done.
+func (s *Scheduler) Start(ctx context.Context) {
+	go s.run(ctx)
+}
+
+// Stop signals the background goroutine to exit and waits for it to finish.
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+// TriggerNow requests an immediate sync. Multiple calls before the scheduler
+// picks one up are coalesced into a single sync.
+func (s *Scheduler) TriggerNow() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+		// a trigger is already pending; the next sync covers this request too
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context) {
+	defer close(s.done)
+
+	interval := s.cfg.BaseInterval
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stop:
+			return
+		case <-s.trigger:
+			interval = s.syncOnce(ctx, interval)
+			resetTimer(timer, interval)
+		case <-timer.C:
+			interval = s.syncOnce(ctx, interval)
+			resetTimer(timer, interval)
+		}
+	}
+}
+
+// syncOnce runs a single sync attempt and returns the interval to wait
+// before the next one: BaseInterval on success, or an exponentially backed
+// off (and jittered) interval on failure.
+func (s *Scheduler) syncOnce(ctx context.Context, prevInterval time.Duration) time.Duration {
+	if err := s.attemptSync(ctx); err != nil {
+		return withJitter(backoff(prevInterval, s.cfg.MaxInterval))
+	}
+	return s.cfg.BaseInterval
+}
+
+// RunNow performs a synchronous, out-of-band sync attempt and records it in
+// the scheduler's counters exactly like a scheduled sync, then returns its
+// error. It's used for the initial sync at startup, so /readyz and
+// friends reflect it immediately instead of waiting for the first tick,
+// and wherever a caller needs a sync to finish before it proceeds.
+func (s *Scheduler) RunNow(ctx context.Context) error {
+	return s.attemptSync(ctx)
+}
+
+// attemptSync runs syncFunc once under SyncTimeout and updates the shared
+// counters, returning syncFunc's error.
+func (s *Scheduler) attemptSync(ctx context.Context) error {
+	syncCtx, cancel := context.WithTimeout(ctx, s.cfg.SyncTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := s.syncFunc(syncCtx)
+	elapsed := time.Since(start)
+
+	atomic.AddUint64(&s.syncsTotal, 1)
+	atomic.StoreInt64(&s.lastSyncUnix, time.Now().Unix())
+	atomic.StoreInt64(&s.lastSyncDuration, elapsed.Milliseconds())
+
+	if err != nil {
+		atomic.AddUint64(&s.syncFailuresTotal, 1)
+		s.setLastError(err.Error())
+		return err
+	}
+
+	atomic.StoreInt64(&s.lastSuccessUnix, time.Now().Unix())
+	s.setLastError("")
+	return nil
+}
+
+func (s *Scheduler) setLastError(msg string) {
+	s.mu.Lock()
+	s.lastError = msg
+	s.mu.Unlock()
+}
+
+// Stats returns a snapshot of the scheduler's counters.
+func (s *Scheduler) Stats() Stats {
+	s.mu.Lock()
+	lastErr := s.lastError
+	s.mu.Unlock()
+
+	return Stats{
+		SyncsTotal:         atomic.LoadUint64(&s.syncsTotal),
+		SyncFailuresTotal:  atomic.LoadUint64(&s.syncFailuresTotal),
+		LastSyncUnix:       atomic.LoadInt64(&s.lastSyncUnix),
+		LastSuccessUnix:    atomic.LoadInt64(&s.lastSuccessUnix),
+		LastSyncDurationMs: atomic.LoadInt64(&s.lastSyncDuration),
+		LastError:          lastErr,
+	}
+}
+
+// MetricsText renders the scheduler's counters in Prometheus text exposition
+// format.
+func (s *Scheduler) MetricsText() string {
+	stats := s.Stats()
+
+	var b strings.Builder
+	b.WriteString("# HELP sync_syncs_total Total number of sync attempts.\n")
+	b.WriteString("# TYPE sync_syncs_total counter\n")
+	fmt.Fprintf(&b, "sync_syncs_total %d\n", stats.SyncsTotal)
+
+	b.WriteString("# HELP sync_failures_total Total number of failed sync attempts.\n")
+	b.WriteString("# TYPE sync_failures_total counter\n")
+	fmt.Fprintf(&b, "sync_failures_total %d\n", stats.SyncFailuresTotal)
+
+	b.WriteString("# HELP sync_last_sync_unix Unix timestamp of the last completed sync attempt.\n")
+	b.WriteString("# TYPE sync_last_sync_unix gauge\n")
+	fmt.Fprintf(&b, "sync_last_sync_unix %d\n", stats.LastSyncUnix)
+
+	b.WriteString("# HELP sync_last_sync_duration_ms Duration of the last sync attempt in milliseconds.\n")
+	b.WriteString("# TYPE sync_last_sync_duration_ms gauge\n")
+	fmt.Fprintf(&b, "sync_last_sync_duration_ms %d\n", stats.LastSyncDurationMs)
+
+	b.WriteString("# HELP sync_last_success_unix Unix timestamp of the last successful sync.\n")
+	b.WriteString("# TYPE sync_last_success_unix gauge\n")
+	fmt.Fprintf(&b, "sync_last_success_unix %d\n", stats.LastSuccessUnix)
+
+	return b.String()
+}
+
+// backoff doubles prevInterval, capped at maxInterval.
+func backoff(prevInterval, maxInterval time.Duration) time.Duration {
+	next := prevInterval * 2
+	if next > maxInterval {
+		next = maxInterval
+	}
+	return next
+}
+
+// withJitter randomizes d by up to +/-20% so that many replicas backing off
+// at once don't retry in lockstep.
+func withJitter(d time.Duration) time.Duration {
+	jitter := time.Duration(rand.Int63n(int64(d) / 5 * 2)) // +/- 20% range (a 40% span)
+	return d - d/5 + jitter
+}
+
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+}
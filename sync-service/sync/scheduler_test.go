@@ -0,0 +1,36 @@
+package sync
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	cases := []struct {
+		prev, max, want time.Duration
+	}{
+		{prev: time.Minute, max: 30 * time.Minute, want: 2 * time.Minute},
+		{prev: 10 * time.Minute, max: 30 * time.Minute, want: 20 * time.Minute},
+		{prev: 20 * time.Minute, max: 30 * time.Minute, want: 30 * time.Minute}, // capped
+		{prev: 30 * time.Minute, max: 30 * time.Minute, want: 30 * time.Minute}, // stays capped
+	}
+
+	for _, c := range cases {
+		if got := backoff(c.prev, c.max); got != c.want {
+			t.Errorf("backoff(%s, %s) = %s, want %s", c.prev, c.max, got, c.want)
+		}
+	}
+}
+
+func TestWithJitter(t *testing.T) {
+	d := 10 * time.Minute
+	low := d - d/5
+	high := d + d/5
+
+	for i := 0; i < 1000; i++ {
+		got := withJitter(d)
+		if got < low || got >= high {
+			t.Fatalf("withJitter(%s) = %s, want in [%s, %s)", d, got, low, high)
+		}
+	}
+}